@@ -0,0 +1,27 @@
+// Package progress provides a structured progress-event abstraction
+// (Output, ProgressReader) and a Tracker that turns those events into
+// transfer telemetry (bytes transferred, throughput, ETA) for subscribers.
+// The download and sparse-receive paths do not write into an Output
+// themselves: they still report to BackingImage.UpdateSyncFileProgress,
+// which forwards each update to the backing image's Tracker.
+package progress
+
+import "time"
+
+// Output receives structured progress events as a transfer proceeds. It is
+// the seam between whatever is doing I/O (a download, a sparse sync) and
+// whatever wants to observe it (BackingImage state, a streaming RPC
+// subscriber).
+type Output interface {
+	WriteProgress(id, action string, current, total int64, lastUpdate time.Time)
+}
+
+// Update is a single structured progress event, as delivered to Tracker
+// subscribers.
+type Update struct {
+	ID         string
+	Action     string
+	Current    int64
+	Total      int64
+	LastUpdate time.Time
+}
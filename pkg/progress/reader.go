@@ -0,0 +1,40 @@
+package progress
+
+import (
+	"io"
+	"time"
+)
+
+// ProgressReader wraps an io.Reader, emitting a structured Output event for
+// every Read so callers can track a download/upload's progress without
+// polling a shared counter.
+type ProgressReader struct {
+	reader io.Reader
+	output Output
+	id     string
+	action string
+
+	current int64
+	total   int64
+}
+
+// NewProgressReader wraps reader so every Read reports current/total bytes
+// transferred to output under the given id/action.
+func NewProgressReader(reader io.Reader, output Output, id, action string, total int64) *ProgressReader {
+	return &ProgressReader{
+		reader: reader,
+		output: output,
+		id:     id,
+		action: action,
+		total:  total,
+	}
+}
+
+func (r *ProgressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.current += int64(n)
+		r.output.WriteProgress(r.id, r.action, r.current, r.total, time.Now())
+	}
+	return n, err
+}
@@ -0,0 +1,126 @@
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultWindow is the moving-average window used for Tracker.Throughput
+// and Tracker.ETA when the caller doesn't need a different one.
+const DefaultWindow = 10 * time.Second
+
+// Tracker is an Output that records every update for throughput/ETA
+// computation over a moving window and fans updates out to any number of
+// Subscribe()rs, e.g. a WatchBackingImage streaming RPC.
+type Tracker struct {
+	Window time.Duration
+
+	lock    sync.Mutex
+	samples []sample
+	subs    map[chan Update]struct{}
+}
+
+type sample struct {
+	at    time.Time
+	bytes int64
+}
+
+// NewTracker creates a Tracker whose Throughput/ETA are computed over a
+// moving average of the given window.
+func NewTracker(window time.Duration) *Tracker {
+	return &Tracker{
+		Window: window,
+		subs:   map[chan Update]struct{}{},
+	}
+}
+
+// WriteProgress implements Output. It records the sample for throughput/ETA
+// computation and fans the update out to every current subscriber.
+//
+// The fan-out happens under the same lock Subscribe's cancel func uses to
+// remove and close a channel, so a send here and a concurrent unsubscribe
+// can never interleave as a send-on-closed-channel panic: either the
+// channel is still in subs and gets the update, or it has already been
+// removed (and possibly closed) and is skipped entirely.
+func (t *Tracker) WriteProgress(id, action string, current, total int64, lastUpdate time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.samples = append(t.samples, sample{at: lastUpdate, bytes: current})
+	cutoff := lastUpdate.Add(-t.Window)
+	i := 0
+	for i < len(t.samples) && t.samples[i].at.Before(cutoff) {
+		i++
+	}
+	t.samples = t.samples[i:]
+
+	update := Update{ID: id, Action: action, Current: current, Total: total, LastUpdate: lastUpdate}
+	for ch := range t.subs {
+		select {
+		case ch <- update:
+		default:
+			// Subscribers only need the latest state; drop rather than
+			// block the transfer on a slow watcher.
+		}
+	}
+}
+
+// Throughput returns the moving-average bytes/sec over Window, based on the
+// samples recorded so far. It returns 0 until at least two samples exist.
+func (t *Tracker) Throughput() float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.samples) < 2 {
+		return 0
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
+
+// ETA estimates the time remaining to reach total bytes at the current
+// throughput. It returns 0 if throughput is unknown or total has already
+// been reached.
+func (t *Tracker) ETA(total int64) time.Duration {
+	t.lock.Lock()
+	var current int64
+	if len(t.samples) > 0 {
+		current = t.samples[len(t.samples)-1].bytes
+	}
+	t.lock.Unlock()
+
+	throughput := t.Throughput()
+	remaining := total - current
+	if throughput <= 0 || remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining)/throughput) * time.Second
+}
+
+// Subscribe registers a new watcher and returns a channel of updates plus a
+// cancel func to unsubscribe. The channel is buffered by 1; updates are
+// dropped rather than blocking the transfer if the subscriber falls behind.
+// The cancel func closes the channel, so callers should consume it with
+// `for update := range ch` and rely on that close to know when to stop.
+func (t *Tracker) Subscribe() (<-chan Update, func()) {
+	ch := make(chan Update, 1)
+	t.lock.Lock()
+	t.subs[ch] = struct{}{}
+	t.lock.Unlock()
+
+	cancel := func() {
+		// Removing from subs and closing happen under the same lock
+		// WriteProgress uses to send, so WriteProgress can never observe a
+		// channel that's both still in subs and already closed.
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		if _, ok := t.subs[ch]; ok {
+			delete(t.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
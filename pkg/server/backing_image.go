@@ -1,12 +1,17 @@
 package server
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -14,11 +19,18 @@ import (
 	"github.com/longhorn/sparse-tools/sparse"
 	sparserest "github.com/longhorn/sparse-tools/sparse/rest"
 
+	"github.com/longhorn/backing-image-manager/pkg/progress"
 	"github.com/longhorn/backing-image-manager/pkg/rpc"
 	"github.com/longhorn/backing-image-manager/pkg/types"
 	"github.com/longhorn/backing-image-manager/pkg/util"
+	"github.com/longhorn/backing-image-manager/pkg/xfer"
 )
 
+// transferProgressAction labels the progress events BackingImage reports to
+// its Tracker; today there is only ever one transfer in flight per backing
+// image, but the label keeps the door open for distinguishing them later.
+const transferProgressAction = "transfer"
+
 type state string
 
 const (
@@ -41,6 +53,17 @@ type BackingImage struct {
 	processedSize int64
 	progress      int
 
+	// pullDone closes once the async goroutine spawned by the most recent
+	// Pull (digest + rename + final notifyUpdate) has completed. Pull
+	// itself returns as soon as the transfer is submitted, well before that
+	// goroutine finishes, so callers that need to wait for the pull to
+	// actually be done (e.g. Manager.BatchPull, to know when it's safe to
+	// lift update suppression) wait on this instead.
+	pullDone chan struct{}
+
+	expectedChecksum string
+	currentChecksum  string
+
 	sendingReference     int
 	senderManagerAddress string
 
@@ -49,17 +72,39 @@ type BackingImage struct {
 
 	log      logrus.FieldLogger
 	updateCh chan interface{}
+
+	// suppressUpdates is set while a Manager batch operation is in flight so
+	// this backing image's own per-call updateCh notifications are skipped
+	// in favor of the single consolidated update the batch sends once all
+	// of its items are done. Accessed atomically since Pull/Receive/Send
+	// run concurrently with the batch dispatch that flips it.
+	suppressUpdates int32
+
+	transferManager *xfer.TransferManager
+	progressTracker *progress.Tracker
+
+	// sendProgressTrackers holds one Tracker per SendMany destination
+	// address, created on first use; a plain Send reports nothing to it.
+	// Each destination gets its own sample series, separate from
+	// progressTracker and from every other destination's: a send's current=0/current=total completion markers
+	// must not land in the same series as another send's, or concurrent
+	// sends to different peers corrupt each other's Throughput/ETA moving
+	// average.
+	sendProgressTrackersLock sync.Mutex
+	sendProgressTrackers     map[string]*progress.Tracker
 }
 
-func NewBackingImage(name, url, uuid, diskPathOnHost string) *BackingImage {
+func NewBackingImage(name, url, uuid, diskPathOnHost, expectedChecksum string) *BackingImage {
 	hostDir := filepath.Join(diskPathOnHost, types.BackingImageDirectoryName, GetBackingImageDirectoryName(name, uuid))
 	workDir := filepath.Join(types.WorkDirectory, GetBackingImageDirectoryName(name, uuid))
 	return &BackingImage{
-		Name:          name,
-		URL:           url,
-		HostDirectory: hostDir,
-		WorkDirectory: workDir,
-		state:         StatePending,
+		Name:             name,
+		URL:              url,
+		UUID:             uuid,
+		HostDirectory:    hostDir,
+		WorkDirectory:    workDir,
+		state:            StatePending,
+		expectedChecksum: expectedChecksum,
 		log: logrus.StandardLogger().WithFields(
 			logrus.Fields{
 				"component": "backing-image",
@@ -70,20 +115,83 @@ func NewBackingImage(name, url, uuid, diskPathOnHost string) *BackingImage {
 				"workDir":   workDir,
 			},
 		),
-		lock: &sync.RWMutex{},
+		lock:                 &sync.RWMutex{},
+		progressTracker:      progress.NewTracker(progress.DefaultWindow),
+		sendProgressTrackers: map[string]*progress.Tracker{},
+		pullDone:             closedChan,
+		// A BackingImage is usable on its own before Manager.Register wires
+		// it up to the manager-wide TransferManager: construct a private one
+		// so Pull/Receive/Send never hit a nil transferManager. Register
+		// replaces this with the shared instance once the backing image is
+		// known to a Manager.
+		transferManager: xfer.NewTransferManager(DefaultMaxConcurrentDownloads, types.SendingLimit, xfer.DefaultBackoffConfig()),
 	}
 }
 
+// closedChan is returned by PullDone for a BackingImage that has never had
+// Pull called on it, so callers can always safely wait on PullDone()
+// without special-casing the no-pull-yet state.
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
 func GetBackingImageDirectoryName(biName, biUUID string) string {
 	return fmt.Sprintf("%s-%s", biName, biUUID)
 }
 
+// runCancelable runs fn on its own goroutine and returns as soon as either fn
+// completes or ctx is done, whichever comes first. It exists for the
+// sparse-tools calls below, which have no context.Context parameter of their
+// own to cancel: returning ctx.Err() promptly lets a cancelled Transfer
+// actually finish instead of waiting out fn, which keeps running in the
+// background against now-discarded state until it returns on its own.
+func runCancelable(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (bi *BackingImage) SetUpdateChannel(updateCh chan interface{}) {
 	bi.updateCh = updateCh
 }
 
-func IntroduceDownloadedBackingImage(name, url, uuid, diskPathOnHost string, size int64) *BackingImage {
-	bi := NewBackingImage(name, url, uuid, diskPathOnHost)
+func (bi *BackingImage) SetTransferManager(transferManager *xfer.TransferManager) {
+	bi.transferManager = transferManager
+}
+
+// setSuppressUpdates controls whether notifyUpdate actually sends to
+// updateCh. A Manager batch operation sets this to true for every item it
+// dispatches so it can send one consolidated update itself once the whole
+// batch completes, instead of one per item.
+func (bi *BackingImage) setSuppressUpdates(suppress bool) {
+	var v int32
+	if suppress {
+		v = 1
+	}
+	atomic.StoreInt32(&bi.suppressUpdates, v)
+}
+
+// notifyUpdate sends on updateCh unless updates are currently suppressed for
+// a Manager batch operation in flight. Every BackingImage method that used
+// to send on updateCh directly should call this instead.
+func (bi *BackingImage) notifyUpdate() {
+	if atomic.LoadInt32(&bi.suppressUpdates) != 0 {
+		return
+	}
+	bi.updateCh <- nil
+}
+
+func IntroduceDownloadedBackingImage(name, url, uuid, diskPathOnHost, expectedChecksum string, size int64) *BackingImage {
+	bi := NewBackingImage(name, url, uuid, diskPathOnHost, expectedChecksum)
 	bi.size = size
 	if name == "" || uuid == "" || diskPathOnHost == "" || size <= 0 {
 		bi.state = types.DownloadStateFailed
@@ -102,13 +210,18 @@ func (bi *BackingImage) Pull() (resp *rpc.BackingImageResponse, err error) {
 			bi.log.WithError(err).Error("Backing Image: failed to pull backing image")
 		}
 		bi.lock.Unlock()
-		bi.updateCh <- nil
+		bi.notifyUpdate()
 	}()
 	bi.log.Info("Backing Image: start to pull backing image")
 
-	if err := bi.prepareForDownload(); err != nil {
+	resumeFrom, err := bi.prepareForDownload()
+	if err != nil {
 		return nil, errors.Wrapf(err, "failed to prepare for pulling")
 	}
+	if resumeFrom > 0 {
+		bi.log.Infof("Backing Image: resuming download from byte %v", resumeFrom)
+		bi.processedSize = resumeFrom
+	}
 
 	size, err := util.GetDownloadSize(bi.URL)
 	if err != nil {
@@ -119,22 +232,68 @@ func (bi *BackingImage) Pull() (resp *rpc.BackingImageResponse, err error) {
 	}
 	bi.size = size
 
+	done := make(chan struct{})
+	bi.pullDone = done
+
+	transferKey := xfer.Key(xfer.OperationPull, bi.UUID, "")
+	transfer := bi.transferManager.Submit(transferKey, xfer.OperationPull, func(ctx context.Context) (interface{}, error) {
+		// Re-stat the tmp file on every attempt: a failed attempt already
+		// wrote bytes to disk, so resuming from the offset captured before
+		// the first attempt would redundantly re-fetch them (or, for a
+		// fresh pull, restart from zero) instead of continuing from the
+		// furthest byte actually written.
+		attemptResumeFrom, statErr := bi.resumeOffset()
+		if statErr != nil {
+			return nil, errors.Wrapf(statErr, "failed to determine resume offset before pulling")
+		}
+		bi.lock.Lock()
+		bi.processedSize = attemptResumeFrom
+		bi.lock.Unlock()
+
+		// util.DownloadFile's own digest only covers the bytes fetched in
+		// this call, which on a resumed pull is a suffix of the file, not
+		// the whole thing; re-hash the whole file ourselves below instead.
+		fetched, _, downloadErr := util.DownloadFile(ctx, bi.URL, filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName), bi, attemptResumeFrom)
+		return attemptResumeFrom + fetched, downloadErr
+	})
+
 	go func() {
 		defer func() {
-			bi.updateCh <- nil
+			bi.notifyUpdate()
+			close(done)
 		}()
 
-		written, err := util.DownloadFile(bi.URL, filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName), bi)
-		if err != nil {
-			bi.lock.Lock()
-			bi.state = StateFailed
-			bi.errorMsg = err.Error()
-			bi.log.WithError(err).Error("Backing Image: failed to pull from remote")
-			bi.lock.Unlock()
-			return
-		}
-		bi.renameFileAndUpdateWithLockAfterDownloadComplete(written)
-		return
+		<-transfer.Done()
+		bi.transferManager.Cancel(transferKey)
+
+		// A second Pull call racing in while the first is still in flight
+		// gets folded into the same Transfer (see xfer.TransferManager.
+		// Submit), so its own goroutine reaches this point too, without its
+		// own work closure ever having run. Gate the apply-result step with
+		// Once so it still happens exactly once per Transfer, no matter how
+		// many watchers are folded into it, reading the result every
+		// watcher shares via Transfer.Result() rather than anything a
+		// deduped watcher's own (never-run) closure would have produced.
+		transfer.Once(func() {
+			if err := transfer.Err(); err != nil {
+				bi.lock.Lock()
+				bi.state = StateFailed
+				bi.errorMsg = err.Error()
+				bi.log.WithError(err).Error("Backing Image: failed to pull from remote")
+				bi.lock.Unlock()
+				return
+			}
+
+			digest, err := digestFile(filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName))
+			if err != nil {
+				bi.log.WithError(err).Warn("Backing Image: failed to compute digest of downloaded file, will skip checksum verification")
+			}
+			// written already accounts for the resumed prefix (see the
+			// Submit closure above), so it's the full on-disk size to
+			// validate against.
+			written, _ := transfer.Result().(int64)
+			bi.renameFileAndUpdateWithLockAfterDownloadComplete(written, digest)
+		})
 	}()
 
 	bi.log.Info("Backing Image: pulling backing image")
@@ -149,7 +308,7 @@ func (bi *BackingImage) Delete() (err error) {
 		currentState := bi.state
 		bi.lock.Unlock()
 		if oldState != currentState {
-			bi.updateCh <- nil
+			bi.notifyUpdate()
 		}
 	}()
 
@@ -175,7 +334,7 @@ func (bi *BackingImage) Get() (*rpc.BackingImageResponse, error) {
 		currentState := bi.state
 		bi.lock.Unlock()
 		if oldState != currentState {
-			bi.updateCh <- nil
+			bi.notifyUpdate()
 		}
 	}()
 
@@ -206,13 +365,13 @@ func (bi *BackingImage) Receive(size int64, senderManagerAddress string, portAll
 			bi.log.WithError(err).Error("Backing Image: failed to receive backing image")
 		}
 		bi.lock.Unlock()
-		bi.updateCh <- nil
+		bi.notifyUpdate()
 	}()
 
 	bi.senderManagerAddress = senderManagerAddress
 	bi.log = bi.log.WithField("senderManagerAddress", senderManagerAddress)
 
-	if err := bi.prepareForDownload(); err != nil {
+	if _, err := bi.prepareForDownload(); err != nil {
 		return 0, errors.Wrapf(err, "failed to prepare for backing image receiving")
 	}
 
@@ -222,26 +381,49 @@ func (bi *BackingImage) Receive(size int64, senderManagerAddress string, portAll
 
 	bi.size = size
 
+	transferKey := xfer.Key(xfer.OperationReceive, bi.UUID, "")
+	transfer := bi.transferManager.Submit(transferKey, xfer.OperationReceive, func(ctx context.Context) (interface{}, error) {
+		bi.log.Infof("Backing Image: prepare to receive backing image at port %v", port)
+		err := runCancelable(ctx, func() error {
+			if err := sparserest.Server(strconv.Itoa(int(port)), filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName), bi); err != nil && err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		})
+		return nil, err
+	})
+
 	go func() {
 		defer func() {
-			bi.updateCh <- nil
+			bi.notifyUpdate()
 			if err := portReleaseFunc(port, port+1); err != nil {
 				bi.log.WithError(err).Errorf("Failed to release port %v after receiving backing image", port)
 			}
 		}()
 
-		bi.log.Infof("Backing Image: prepare to receive backing image at port %v", port)
+		<-transfer.Done()
+		bi.transferManager.Cancel(transferKey)
+
+		// Same fold-by-dedup hazard as Pull: a second Receive racing in
+		// while one is in flight shares this Transfer, so gate the apply
+		// step with Once rather than letting every watcher's goroutine run
+		// it (and redundantly os.Rename an already-moved file).
+		transfer.Once(func() {
+			if err := transfer.Err(); err != nil {
+				bi.lock.Lock()
+				bi.state = StateFailed
+				bi.errorMsg = err.Error()
+				bi.log.WithError(err).Errorf("Backing Image: failed to receive backing image from %v", senderManagerAddress)
+				bi.lock.Unlock()
+				return
+			}
 
-		if err := sparserest.Server(strconv.Itoa(int(port)), filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName), bi); err != nil && err != http.ErrServerClosed {
-			bi.lock.Lock()
-			bi.state = StateFailed
-			bi.errorMsg = err.Error()
-			bi.log.WithError(err).Errorf("Backing Image: failed to receive backing image from %v", senderManagerAddress)
-			bi.lock.Unlock()
-			return
-		}
-		bi.renameFileAndUpdateWithLockAfterDownloadComplete(size)
-		return
+			digest, err := digestFile(filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName))
+			if err != nil {
+				bi.log.WithError(err).Warn("Backing Image: failed to compute digest of received file, will skip checksum verification")
+			}
+			bi.renameFileAndUpdateWithLockAfterDownloadComplete(size, digest)
+		})
 	}()
 
 	return port, nil
@@ -254,7 +436,7 @@ func (bi *BackingImage) Send(address string, portAllocateFunc func(portCount int
 		currentState := bi.state
 		bi.lock.Unlock()
 		if oldState != currentState {
-			bi.updateCh <- nil
+			bi.notifyUpdate()
 		}
 	}()
 
@@ -278,19 +460,29 @@ func (bi *BackingImage) Send(address string, portAllocateFunc func(portCount int
 
 	bi.sendingReference++
 
-	go func() {
+	transferKey := xfer.Key(xfer.OperationSend, bi.UUID, address)
+	transfer := bi.transferManager.Submit(transferKey, xfer.OperationSend, func(ctx context.Context) (interface{}, error) {
 		bi.log.Infof("Backing Image: start to send backing image to address %v", address)
+		err := runCancelable(ctx, func() error {
+			return sparse.SyncFile(filepath.Join(bi.WorkDirectory, types.BackingImageFileName), address, types.FileSyncTimeout, false)
+		})
+		return nil, err
+	})
+
+	go func() {
 		defer func() {
 			bi.lock.Lock()
 			bi.sendingReference--
 			bi.lock.Unlock()
-			bi.updateCh <- nil
+			bi.notifyUpdate()
 			if err := portReleaseFunc(port, port+1); err != nil {
 				bi.log.WithError(err).Errorf("Failed to release port %v after sending backing image", port)
 			}
 		}()
 
-		if err := sparse.SyncFile(filepath.Join(bi.WorkDirectory, types.BackingImageFileName), address, types.FileSyncTimeout, false); err != nil {
+		<-transfer.Done()
+		bi.transferManager.Cancel(transferKey)
+		if err := transfer.Err(); err != nil {
 			bi.log.WithError(err).Errorf("Backing Image: failed to send backing image to address %v", address)
 			return
 		}
@@ -300,14 +492,122 @@ func (bi *BackingImage) Send(address string, portAllocateFunc func(portCount int
 	return nil
 }
 
+// SendMany fans a Send out to many destinations at once: it allocates one
+// port per destination and launches the sparse sends concurrently, bounded
+// by the same TransferManager upload cap and per-address dedup used by
+// Send. sparse-tools reads the backing image file independently per
+// destination; there is no hook in its API today to share those reads
+// across destinations, so unlike Send's single transfer this does cost one
+// disk read per destination.
+func (bi *BackingImage) SendMany(addresses []string, portAllocateFunc func(portCount int32) (int32, int32, error), portReleaseFunc func(start, end int32) error) (err error) {
+	bi.lock.Lock()
+	oldState := bi.state
+	defer func() {
+		currentState := bi.state
+		bi.lock.Unlock()
+		if oldState != currentState {
+			bi.notifyUpdate()
+		}
+	}()
+
+	if bi.state != types.DownloadStateDownloaded {
+		return fmt.Errorf("backing image %v with state %v is invalid for file sending", bi.Name, bi.state)
+	}
+	if err := bi.validateFiles(); err != nil {
+		bi.state = StateFailed
+		bi.errorMsg = err.Error()
+		bi.log.WithError(err).Error("Backing Image: failed to validate files before sending")
+		return errors.Wrapf(err, "cannot send backing image %v to others since the files are invalid", bi.Name)
+	}
+	if bi.sendingReference+len(addresses) > types.SendingLimit {
+		return fmt.Errorf("backing image %v cannot send to %v more destinations on top of %v in-flight: sending limit is %v", bi.Name, len(addresses), bi.sendingReference, types.SendingLimit)
+	}
+
+	startPort, _, err := portAllocateFunc(int32(len(addresses)))
+	if err != nil {
+		return errors.Wrapf(err, "failed to request %v ports for sending backing image to many destinations", len(addresses))
+	}
+
+	bi.sendingReference += len(addresses)
+
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		port := startPort + int32(i)
+		wg.Add(1)
+		go func(address string, port int32) {
+			defer wg.Done()
+			bi.sendOneOfMany(address, port)
+
+			bi.lock.Lock()
+			bi.sendingReference--
+			bi.lock.Unlock()
+			bi.notifyUpdate()
+		}(address, port)
+	}
+
+	go func() {
+		wg.Wait()
+		if err := portReleaseFunc(startPort, startPort+int32(len(addresses))); err != nil {
+			bi.log.WithError(err).Errorf("Failed to release ports %v-%v after sending backing image to many destinations", startPort, startPort+int32(len(addresses)))
+		}
+	}()
+
+	return nil
+}
+
+// sendOneOfMany performs one destination's leg of a SendMany, deduplicating
+// against any concurrent Send/SendMany to the same address via the
+// TransferManager and reporting the outcome through the progress subsystem.
+func (bi *BackingImage) sendOneOfMany(address string, port int32) {
+	bi.log.Infof("Backing Image: start to send backing image to address %v via port %v (SendMany)", address, port)
+
+	transferKey := xfer.Key(xfer.OperationSend, bi.UUID, address)
+	transfer := bi.transferManager.Submit(transferKey, xfer.OperationSend, func(ctx context.Context) (interface{}, error) {
+		err := runCancelable(ctx, func() error {
+			return sparse.SyncFile(filepath.Join(bi.WorkDirectory, types.BackingImageFileName), address, types.FileSyncTimeout, false)
+		})
+		return nil, err
+	})
+
+	bi.lock.RLock()
+	total := bi.size
+	bi.lock.RUnlock()
+
+	tracker := bi.sendTrackerFor(address)
+
+	<-transfer.Done()
+	bi.transferManager.Cancel(transferKey)
+	if err := transfer.Err(); err != nil {
+		bi.log.WithError(err).Errorf("Backing Image: failed to send backing image to address %v", address)
+		tracker.WriteProgress(bi.UUID, address, 0, total, time.Now())
+		return
+	}
+	bi.log.Infof("Backing Image: done sending backing image to address %v", address)
+	tracker.WriteProgress(bi.UUID, address, total, total, time.Now())
+}
+
+// sendTrackerFor returns the progress.Tracker scoped to a single SendMany
+// destination address, creating it on first use.
+func (bi *BackingImage) sendTrackerFor(address string) *progress.Tracker {
+	bi.sendProgressTrackersLock.Lock()
+	defer bi.sendProgressTrackersLock.Unlock()
+	t, ok := bi.sendProgressTrackers[address]
+	if !ok {
+		t = progress.NewTracker(progress.DefaultWindow)
+		bi.sendProgressTrackers[address] = t
+	}
+	return t
+}
+
 func (bi *BackingImage) rpcResponse() *rpc.BackingImageResponse {
 	resp := &rpc.BackingImageResponse{
 		Spec: &rpc.BackingImageSpec{
-			Name:      bi.Name,
-			Url:       bi.URL,
-			Uuid:      bi.UUID,
-			Size:      bi.size,
-			Directory: bi.HostDirectory,
+			Name:             bi.Name,
+			Url:              bi.URL,
+			Uuid:             bi.UUID,
+			Size:             bi.size,
+			Directory:        bi.HostDirectory,
+			ExpectedChecksum: bi.expectedChecksum,
 		},
 
 		Status: &rpc.BackingImageStatus{
@@ -316,17 +616,21 @@ func (bi *BackingImage) rpcResponse() *rpc.BackingImageResponse {
 			ErrorMsg:             bi.errorMsg,
 			SenderManagerAddress: bi.senderManagerAddress,
 			DownloadProgress:     int32(bi.progress),
+			CurrentChecksum:      bi.currentChecksum,
 		},
 	}
 	return resp
 }
 
-func (bi *BackingImage) prepareForDownload() error {
+// prepareForDownload readies the work directory for a Pull/Receive and
+// returns how many bytes of a previous, incomplete download can be resumed
+// from (0 if there is nothing to resume).
+func (bi *BackingImage) prepareForDownload() (int64, error) {
 	if _, err := os.Stat(bi.WorkDirectory); os.IsNotExist(err) {
 		if err := os.Mkdir(bi.WorkDirectory, 666); err != nil {
-			return errors.Wrapf(err, "failed to create work directory %v before downloading", bi.WorkDirectory)
+			return 0, errors.Wrapf(err, "failed to create work directory %v before downloading", bi.WorkDirectory)
 		}
-		return nil
+		return 0, nil
 	}
 
 	// Try to reuse the existing file if possible
@@ -335,19 +639,38 @@ func (bi *BackingImage) prepareForDownload() error {
 	if _, err := os.Stat(backingImagePath); os.IsExist(err) {
 		if _, err := os.Stat(backingImageTmpPath); os.IsExist(err) {
 			if err := os.Remove(backingImageTmpPath); err != nil {
-				return errors.Wrapf(err, "failed to delete tmp file %v before trying to reuse file %v", backingImageTmpPath, backingImagePath)
+				return 0, errors.Wrapf(err, "failed to delete tmp file %v before trying to reuse file %v", backingImageTmpPath, backingImagePath)
 			}
 		}
 		if err := os.Rename(backingImagePath, backingImageTmpPath); err != nil {
 			bi.log.WithError(err).Warnf("Backing Image: failed to rename existing file %v to tmp file %v before trying to reuse it, will fall back to clean up it", backingImagePath, backingImageTmpPath)
 			if err := os.Remove(backingImagePath); err != nil {
-				return errors.Wrapf(err, "failed to delete file %v before downloading", backingImagePath)
+				return 0, errors.Wrapf(err, "failed to delete file %v before downloading", backingImagePath)
 			}
 		}
-		return nil
+		return 0, nil
 	}
 
-	return nil
+	// A tmp file left over from a previous, interrupted download can be
+	// resumed via an HTTP Range request instead of restarting from zero.
+	return bi.resumeOffset()
+}
+
+// resumeOffset returns how many bytes of bi's tmp file are already on disk
+// and can be resumed from via an HTTP Range request (0 if there's nothing
+// to resume, e.g. the tmp file doesn't exist). Unlike prepareForDownload,
+// which also handles first-time setup of the work directory, this only
+// stats the tmp file, so it's safe to call again between retry attempts of
+// the same Pull to pick up bytes a previous, failed attempt already wrote.
+func (bi *BackingImage) resumeOffset() (int64, error) {
+	info, err := os.Stat(filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to stat tmp file before determining resume offset")
+	}
+	return info.Size(), nil
 }
 
 func (bi *BackingImage) validateFiles() error {
@@ -374,7 +697,7 @@ func (bi *BackingImage) validateFiles() error {
 	return nil
 }
 
-func (bi *BackingImage) renameFileAndUpdateWithLockAfterDownloadComplete(size int64) {
+func (bi *BackingImage) renameFileAndUpdateWithLockAfterDownloadComplete(size int64, digest string) {
 	backingImageTmpPath := filepath.Join(bi.WorkDirectory, types.BackingImageTmpFileName)
 	backingImagePath := filepath.Join(bi.WorkDirectory, types.BackingImageFileName)
 
@@ -393,6 +716,14 @@ func (bi *BackingImage) renameFileAndUpdateWithLockAfterDownloadComplete(size in
 		return
 	}
 
+	bi.currentChecksum = digest
+	if bi.expectedChecksum != "" && digest != bi.expectedChecksum {
+		bi.state = StateFailed
+		bi.errorMsg = fmt.Errorf("digest %v of downloaded file doesn't match expected checksum %v", digest, bi.expectedChecksum).Error()
+		bi.log.Errorf("Backing Image: %s", bi.errorMsg)
+		return
+	}
+
 	if err := os.Rename(backingImageTmpPath, backingImagePath); err != nil {
 		bi.state = StateFailed
 		bi.errorMsg = errors.Wrapf(err, "failed to rename backing image file after downloading").Error()
@@ -406,10 +737,25 @@ func (bi *BackingImage) renameFileAndUpdateWithLockAfterDownloadComplete(size in
 	return
 }
 
+// digestFile computes the sha256 content digest of the file at path,
+// formatted as "sha256:<hex>" to match the content-addressable style used
+// elsewhere in the cluster.
+func digestFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open %v to compute digest", path)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "failed to read %v to compute digest", path)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
 func (bi *BackingImage) UpdateSyncFileProgress(size int64) {
 	bi.lock.Lock()
-	defer bi.lock.Unlock()
-
 	if bi.state == types.DownloadStatePending {
 		bi.state = types.DownloadStateDownloading
 	}
@@ -418,4 +764,29 @@ func (bi *BackingImage) UpdateSyncFileProgress(size int64) {
 	if bi.size > 0 {
 		bi.progress = int((float32(bi.processedSize) / float32(bi.size)) * 100)
 	}
+	processedSize, total := bi.processedSize, bi.size
+	bi.lock.Unlock()
+
+	bi.progressTracker.WriteProgress(bi.UUID, transferProgressAction, processedSize, total, time.Now())
+}
+
+// currentState returns the current state under lock, for callers outside
+// the package (e.g. Manager.WatchBackingImage) that need it alongside
+// progress updates.
+func (bi *BackingImage) currentState() state {
+	bi.lock.RLock()
+	defer bi.lock.RUnlock()
+	return bi.state
+}
+
+// PullDone returns a channel that closes once the async goroutine spawned
+// by the most recently started Pull has completed: digest verification,
+// rename, and the final notifyUpdate are all done. Pull itself returns as
+// soon as the transfer is submitted, so a caller that needs to know when a
+// pull has actually finished (e.g. Manager.BatchPull deciding when it's
+// safe to lift update suppression) waits on this instead.
+func (bi *BackingImage) PullDone() <-chan struct{} {
+	bi.lock.RLock()
+	defer bi.lock.RUnlock()
+	return bi.pullDone
 }
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentPullDedupesAndAppliesResultOnce is the regression test for
+// a second Pull call racing in while one is already in flight for the same
+// backing image: TransferManager.Submit folds it into the same Transfer,
+// so its own work closure never runs. Before Transfer carried a shared
+// Result and Once, that caller's completion goroutine still ran with a
+// zero local `written`, tripping renameFileAndUpdateWithLockAfterDownload
+// Complete's size check and flipping the backing image to StateFailed no
+// matter which of the two completion goroutines ran last.
+func TestConcurrentPullDedupesAndAppliesResultOnce(t *testing.T) {
+	content := []byte("hello backing image")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var startedOnce sync.Once
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			return
+		}
+		startedOnce.Do(func() { close(started) })
+		<-release
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	bi := NewBackingImage("test-backing-image", srv.URL, "test-uuid", t.TempDir(), "")
+	bi.WorkDirectory = t.TempDir()
+	bi.updateCh = make(chan interface{}, 16)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := bi.Pull(); err != nil {
+			t.Errorf("first Pull returned error: %v", err)
+		}
+	}()
+	<-started
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := bi.Pull(); err != nil {
+			t.Errorf("second, deduped Pull returned error: %v", err)
+		}
+	}()
+	// Give the second Pull time to reach Submit and fold into the first's
+	// still-in-flight Transfer before the download is allowed to complete.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+	<-bi.PullDone()
+
+	if state := bi.currentState(); state != StateDownloaded {
+		t.Fatalf("expected state %v after two concurrent Pulls for the same backing image, got %v (error: %v)", StateDownloaded, state, bi.errorMsg)
+	}
+}
@@ -0,0 +1,35 @@
+package xfer
+
+import "time"
+
+// BackoffConfig controls the exponential backoff used between retry
+// attempts of a failed Transfer.
+type BackoffConfig struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// MaxAttempts is the total number of times work is invoked before the
+	// transfer is given up on, i.e. it includes the first try: a failure on
+	// attempt MaxAttempts-1 is not retried.
+	MaxAttempts int
+}
+
+// DefaultBackoffConfig returns sane defaults for retrying flaky
+// downloads/uploads without hammering the remote end.
+func DefaultBackoffConfig() BackoffConfig {
+	return BackoffConfig{
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		MaxAttempts: 5,
+	}
+}
+
+// Delay returns the backoff delay before the given retry attempt
+// (0-indexed: attempt 0 is the delay before the first retry).
+func (c BackoffConfig) Delay(attempt int) time.Duration {
+	delay := c.BaseDelay << uint(attempt)
+	if delay <= 0 || delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,186 @@
+// Package xfer implements a transfer manager modeled on Docker's
+// download/upload manager: it deduplicates concurrent requests for the same
+// backing image operation, bounds how many downloads/uploads run at once,
+// and retries failed transfers with exponential backoff before giving up.
+package xfer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OperationType identifies the kind of transfer being deduplicated so that,
+// for example, a Pull and a Send for the same backing image UUID don't
+// collide in the registry.
+type OperationType string
+
+const (
+	OperationPull    = OperationType("pull")
+	OperationReceive = OperationType("receive")
+	OperationSend    = OperationType("send")
+)
+
+// Key builds the registry key for a transfer: the backing image UUID plus
+// the operation type, with an optional extra discriminator (e.g. the
+// destination address for a Send, so sends to different peers don't fold
+// into one transfer).
+func Key(opType OperationType, uuid, extra string) string {
+	if extra == "" {
+		return fmt.Sprintf("%s-%s", uuid, opType)
+	}
+	return fmt.Sprintf("%s-%s-%s", uuid, opType, extra)
+}
+
+// Work is the unit of retryable work submitted to the TransferManager. It
+// should respect ctx cancellation and return promptly once ctx is done. The
+// result value, if any, is recorded on the Transfer by a successful
+// attempt and read back via Transfer.Result() — every caller folded into
+// the same Transfer by Submit's dedup shares this one result, since only
+// one Work invocation ever actually runs.
+type Work func(ctx context.Context) (result interface{}, err error)
+
+// TransferManager schedules Pull/Receive/Send work, folding duplicate
+// requests for the same key into a single in-flight Transfer and retrying
+// failures with exponential backoff before surfacing the error.
+type TransferManager struct {
+	downloadTokens chan struct{}
+	uploadTokens   chan struct{}
+	backoff        BackoffConfig
+
+	lock     sync.Mutex
+	registry map[string]*Transfer
+
+	log logrus.FieldLogger
+}
+
+// NewTransferManager creates a TransferManager that runs at most
+// maxConcurrentDownloads download-type transfers (OperationPull,
+// OperationReceive) and maxConcurrentUploads upload-type transfers
+// (OperationSend) at once.
+func NewTransferManager(maxConcurrentDownloads, maxConcurrentUploads int, backoff BackoffConfig) *TransferManager {
+	return &TransferManager{
+		downloadTokens: make(chan struct{}, maxConcurrentDownloads),
+		uploadTokens:   make(chan struct{}, maxConcurrentUploads),
+		backoff:        backoff,
+		registry:       map[string]*Transfer{},
+		log:            logrus.StandardLogger().WithField("component", "transfer-manager"),
+	}
+}
+
+func (m *TransferManager) tokens(opType OperationType) chan struct{} {
+	if opType == OperationSend {
+		return m.uploadTokens
+	}
+	return m.downloadTokens
+}
+
+// Submit schedules work under key. If a transfer for key is already
+// in-flight, the caller is folded into it (reference counted) instead of
+// starting a second one, and the existing Transfer is returned.
+func (m *TransferManager) Submit(key string, opType OperationType, work Work) *Transfer {
+	m.lock.Lock()
+	if t, ok := m.registry[key]; ok {
+		t.watch()
+		m.lock.Unlock()
+		return t
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := newTransfer(key, ctx, cancel)
+	m.registry[key] = t
+	m.lock.Unlock()
+
+	go m.run(t, opType, work)
+
+	return t
+}
+
+// finishAndRemove deletes t from the registry before calling finish, so
+// that a Submit racing in for the same key can never see a Transfer that's
+// still registered but already completed (and whose Done()/Result() would
+// be stale leftovers from a run that already happened, with no new work
+// ever started to produce a fresh result).
+func (m *TransferManager) finishAndRemove(t *Transfer, result interface{}, err error) {
+	m.lock.Lock()
+	if m.registry[t.key] == t {
+		delete(m.registry, t.key)
+	}
+	m.lock.Unlock()
+	t.finish(result, err)
+}
+
+func (m *TransferManager) run(t *Transfer, opType OperationType, work Work) {
+	tokens := m.tokens(opType)
+
+	select {
+	case tokens <- struct{}{}:
+		defer func() { <-tokens }()
+	case <-t.ctx.Done():
+		m.finishAndRemove(t, nil, t.ctx.Err())
+		return
+	}
+
+	var err error
+	var result interface{}
+	for attempt := 0; attempt < m.backoff.MaxAttempts; attempt++ {
+		result, err = work(t.ctx)
+		if err == nil {
+			m.finishAndRemove(t, result, nil)
+			return
+		}
+		if t.ctx.Err() != nil {
+			m.finishAndRemove(t, nil, t.ctx.Err())
+			return
+		}
+		if attempt == m.backoff.MaxAttempts-1 {
+			break
+		}
+
+		m.log.WithError(err).Warnf("Transfer %v: attempt %v failed, retrying", t.key, attempt+1)
+
+		select {
+		case <-time.After(m.backoff.Delay(attempt)):
+		case <-t.ctx.Done():
+			m.finishAndRemove(t, nil, t.ctx.Err())
+			return
+		}
+	}
+
+	m.finishAndRemove(t, nil, err)
+}
+
+// Cancel releases the caller's interest in the transfer for key. If the
+// caller was the last remaining watcher, the underlying work is cancelled.
+// It is not an error to cancel a key with no in-flight transfer.
+func (m *TransferManager) Cancel(key string) error {
+	m.lock.Lock()
+	t, ok := m.registry[key]
+	m.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	t.unwatch()
+	return nil
+}
+
+// ForceCancel aborts the in-flight transfer for key outright, regardless of
+// how many callers are still watching it. Unlike Cancel, which only
+// releases the caller's own interest and leaves the transfer running for
+// any other watcher, this is for an explicit cancel request (e.g. a
+// CancelTransfer RPC) where the caller means to abort the transfer now,
+// dedup refcounting notwithstanding. It is not an error to cancel a key
+// with no in-flight transfer.
+func (m *TransferManager) ForceCancel(key string) error {
+	m.lock.Lock()
+	t, ok := m.registry[key]
+	m.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	t.cancel()
+	return nil
+}
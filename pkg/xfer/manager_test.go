@@ -0,0 +1,300 @@
+package xfer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testBackoff() BackoffConfig {
+	return BackoffConfig{
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+		MaxAttempts: 3,
+	}
+}
+
+func TestSubmitDedupesConcurrentCallers(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+
+	work := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-release
+		return nil, nil
+	}
+
+	t1 := m.Submit("key", OperationPull, work)
+	<-started
+	t2 := m.Submit("key", OperationPull, work)
+
+	if t1 != t2 {
+		t.Fatalf("expected Submit to fold a duplicate key into the same Transfer")
+	}
+
+	close(release)
+	<-t1.Done()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected work to run once for a deduped key, ran %v times", got)
+	}
+	if err := t1.Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+// TestDedupedCallerSharesResult is the regression test for a caller folded
+// into an in-flight Transfer by dedup: its own work closure never runs, so
+// it must read the transfer's actual result via Result() rather than
+// relying on anything its own (never-executed) closure would have set.
+func TestDedupedCallerSharesResult(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	work := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return 42, nil
+	}
+
+	t1 := m.Submit("key", OperationPull, work)
+	<-started
+	t2 := m.Submit("key", OperationPull, func(ctx context.Context) (interface{}, error) {
+		t.Fatal("work for a deduped caller must not run")
+		return nil, nil
+	})
+
+	close(release)
+	<-t1.Done()
+	<-t2.Done()
+
+	if t1.Result() != 42 || t2.Result() != 42 {
+		t.Fatalf("expected both watchers to observe the shared result 42, got %v and %v", t1.Result(), t2.Result())
+	}
+}
+
+// TestOnceRunsExactlyOnceAcrossWatchers is the regression test for a
+// completion step (e.g. Pull's digest/rename/state-transition) gated on
+// Transfer.Once: every watcher folded into the same Transfer races to call
+// it, but it must only actually run once.
+func TestOnceRunsExactlyOnceAcrossWatchers(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	release := make(chan struct{})
+	work := func(ctx context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	}
+
+	t1 := m.Submit("key", OperationPull, work)
+	t2 := m.Submit("key", OperationPull, work)
+	close(release)
+	<-t1.Done()
+	<-t2.Done()
+
+	var applied int32
+	var wg sync.WaitGroup
+	for _, transfer := range []*Transfer{t1, t2} {
+		wg.Add(1)
+		go func(transfer *Transfer) {
+			defer wg.Done()
+			transfer.Once(func() { atomic.AddInt32(&applied, 1) })
+		}(transfer)
+	}
+	wg.Wait()
+
+	if applied != 1 {
+		t.Fatalf("expected Once to run exactly once across all watchers of one Transfer, ran %v times", applied)
+	}
+}
+
+func TestRetriesUntilMaxAttempts(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	var calls int32
+	failure := errors.New("boom")
+	work := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, failure
+	}
+
+	transfer := m.Submit("key", OperationPull, work)
+	<-transfer.Done()
+
+	if got := atomic.LoadInt32(&calls); got != int32(testBackoff().MaxAttempts) {
+		t.Fatalf("expected work to run MaxAttempts=%v times, ran %v times", testBackoff().MaxAttempts, got)
+	}
+	if !errors.Is(transfer.Err(), failure) {
+		t.Fatalf("expected final error to be the last failure, got %v", transfer.Err())
+	}
+}
+
+func TestRetriesStopOnSuccess(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	var calls int32
+	work := func(ctx context.Context) (interface{}, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if n < 2 {
+			return nil, errors.New("transient")
+		}
+		return nil, nil
+	}
+
+	transfer := m.Submit("key", OperationPull, work)
+	<-transfer.Done()
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected work to stop retrying after success on attempt 2, ran %v times", got)
+	}
+	if err := transfer.Err(); err != nil {
+		t.Fatalf("expected no error after eventual success, got %v", err)
+	}
+}
+
+// TestSubmitAfterCompletionStartsFreshTransfer is the regression test for
+// registry cleanup racing finish(): the registry entry for a completed
+// Transfer must already be gone by the time Done() unblocks watchers, or a
+// Submit racing in right after could fold into the finished (and now
+// stale) Transfer instead of starting the new one it asked for.
+func TestSubmitAfterCompletionStartsFreshTransfer(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	var calls int32
+	work := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	}
+
+	t1 := m.Submit("key", OperationPull, work)
+	<-t1.Done()
+
+	t2 := m.Submit("key", OperationPull, work)
+	<-t2.Done()
+
+	if t1 == t2 {
+		t.Fatalf("expected Submit after completion to start a fresh Transfer, got the same one back")
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected work to run once per Submit after the prior transfer completed, ran %v times", got)
+	}
+}
+
+func TestCancelAbortsWhenLastWatcherLeaves(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	started := make(chan struct{})
+	work := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	key := "key"
+	t1 := m.Submit(key, OperationPull, work)
+	<-started
+	t2 := m.Submit(key, OperationPull, work)
+
+	// Both callers must unwatch before the underlying work is cancelled.
+	if err := m.Cancel(key); err != nil {
+		t.Fatalf("unexpected error from Cancel: %v", err)
+	}
+	select {
+	case <-t1.Done():
+		t.Fatalf("transfer finished after only one of two watchers cancelled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := m.Cancel(key); err != nil {
+		t.Fatalf("unexpected error from Cancel: %v", err)
+	}
+	<-t1.Done()
+
+	if !errors.Is(t1.Err(), context.Canceled) {
+		t.Fatalf("expected context.Canceled once the last watcher left, got %v", t1.Err())
+	}
+	_ = t2
+}
+
+func TestCancelUnknownKeyIsNoOp(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+	if err := m.Cancel("does-not-exist"); err != nil {
+		t.Fatalf("expected Cancel on an unknown key to be a no-op, got %v", err)
+	}
+}
+
+func TestForceCancelAbortsRegardlessOfWatchers(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	started := make(chan struct{})
+	work := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	key := "key"
+	t1 := m.Submit(key, OperationPull, work)
+	<-started
+	t2 := m.Submit(key, OperationPull, work)
+
+	// Unlike Cancel, a single ForceCancel aborts the transfer even though
+	// two callers are still watching it.
+	if err := m.ForceCancel(key); err != nil {
+		t.Fatalf("unexpected error from ForceCancel: %v", err)
+	}
+	<-t1.Done()
+
+	if !errors.Is(t1.Err(), context.Canceled) {
+		t.Fatalf("expected context.Canceled after ForceCancel, got %v", t1.Err())
+	}
+	_ = t2
+}
+
+func TestForceCancelUnknownKeyIsNoOp(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+	if err := m.ForceCancel("does-not-exist"); err != nil {
+		t.Fatalf("expected ForceCancel on an unknown key to be a no-op, got %v", err)
+	}
+}
+
+func TestTokensBoundConcurrency(t *testing.T) {
+	m := NewTransferManager(1, 1, testBackoff())
+
+	var inFlight int32
+	var maxInFlight int32
+	var lock sync.Mutex
+	release := make(chan struct{})
+
+	work := func(ctx context.Context) (interface{}, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		lock.Lock()
+		if n > maxInFlight {
+			maxInFlight = n
+		}
+		lock.Unlock()
+		<-release
+		atomic.AddInt32(&inFlight, -1)
+		return nil, nil
+	}
+
+	t1 := m.Submit("key-1", OperationPull, work)
+	t2 := m.Submit("key-2", OperationPull, work)
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-t1.Done()
+	<-t2.Done()
+
+	if maxInFlight > 1 {
+		t.Fatalf("expected at most 1 download in flight at once, saw %v", maxInFlight)
+	}
+}
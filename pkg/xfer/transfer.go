@@ -0,0 +1,105 @@
+package xfer
+
+import (
+	"context"
+	"sync"
+)
+
+// Transfer tracks a single in-flight Pull/Receive/Send operation. Multiple
+// callers that ask for the same key share one Transfer via reference
+// counting, so the underlying work is only cancelled once the last watcher
+// walks away.
+type Transfer struct {
+	key string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	doneCh chan struct{}
+
+	lock      sync.Mutex
+	refCount  int
+	err       error
+	result    interface{}
+	completed bool
+
+	applyOnce sync.Once
+}
+
+func newTransfer(key string, ctx context.Context, cancel context.CancelFunc) *Transfer {
+	return &Transfer{
+		key:      key,
+		ctx:      ctx,
+		cancel:   cancel,
+		doneCh:   make(chan struct{}),
+		refCount: 1,
+	}
+}
+
+// Done returns a channel that is closed once the transfer has finished,
+// successfully or not. Check Err() afterwards for the result.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.doneCh
+}
+
+// Err returns the terminal error of the transfer, if any. It is only safe
+// to call after Done() has been closed.
+func (t *Transfer) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.err
+}
+
+// Result returns the value a successful Work returned, if any. It is only
+// safe to call after Done() has been closed. Every caller folded into this
+// Transfer by Submit's dedup sees the same Result, since only the one
+// actual Work invocation ever runs — callers must read it from here rather
+// than from any state their own submitting goroutine tried to capture
+// locally, which would only reflect the work a deduped caller never ran.
+func (t *Transfer) Result() interface{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.result
+}
+
+// Once runs fn exactly once for this Transfer, no matter how many watchers
+// folded into it by dedup call it concurrently after completion. Callers
+// use this to gate a side effect that must happen exactly once per
+// transfer even though every watcher's own goroutine races to be the one
+// that applies it — e.g. a Pull's digest/rename/state-transition step.
+func (t *Transfer) Once(fn func()) {
+	t.applyOnce.Do(fn)
+}
+
+func (t *Transfer) finish(result interface{}, err error) {
+	t.lock.Lock()
+	if t.completed {
+		t.lock.Unlock()
+		return
+	}
+	t.completed = true
+	t.result = result
+	t.err = err
+	t.lock.Unlock()
+	close(t.doneCh)
+}
+
+// watch registers an additional caller interested in this transfer.
+func (t *Transfer) watch() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.refCount++
+}
+
+// unwatch drops a caller's interest in this transfer. Once the last watcher
+// unwatches, the transfer's context is cancelled so the underlying work can
+// abort.
+func (t *Transfer) unwatch() {
+	t.lock.Lock()
+	t.refCount--
+	shouldCancel := t.refCount <= 0
+	t.lock.Unlock()
+	if shouldCancel {
+		t.cancel()
+	}
+}